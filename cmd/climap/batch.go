@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/yargevad/mailtools/imaputil"
+	"github.com/yargevad/mailtools/mimeutil"
+)
+
+// RunConfig iterates every rule in cfg against the account it names,
+// searches for matching messages, and saves any attachments whose filename matches the rule's
+// regexp to the rule's (templated) path.
+func RunConfig(cfg *imaputil.Config) error {
+	for _, rule := range cfg.Rule {
+		acct, err := cfg.AccountByName(rule.Account)
+		if err != nil {
+			return err
+		}
+
+		re, err := regexp.Compile(rule.Regexp)
+		if err != nil {
+			return fmt.Errorf("bad regexp for rule [%s/%s]: %s", acct.Name, rule.Mailbox, err)
+		}
+
+		ctx := &imaputil.ImapCtx{Host: acct.Host, User: acct.User, Pass: acct.Pass}
+		if acct.TLSServerName != "" {
+			ctx.TLS.ServerName = acct.TLSServerName
+		}
+
+		err = ctx.Init()
+		if err != nil {
+			return err
+		}
+
+		err = ctx.Mailbox(rule.Mailbox)
+		if err != nil {
+			ctx.IMAP.Logout(10 * time.Second)
+			return err
+		}
+
+		terms, err := ctx.SearchTerms(&rule)
+		if err != nil {
+			ctx.IMAP.Logout(10 * time.Second)
+			return err
+		}
+
+		uids, err := ctx.Search(terms)
+		if err != nil {
+			ctx.IMAP.Logout(10 * time.Second)
+			return err
+		}
+
+		log.Printf("[%s/%s] %d messages matched\n", acct.Name, rule.Mailbox, len(uids))
+		for _, uid := range uids {
+			err = saveMatchingAttachment(ctx, uid, re, rule.Path)
+			if err != nil {
+				log.Printf("[%s/%s] uid=%d: %s\n", acct.Name, rule.Mailbox, uid, err)
+			}
+		}
+
+		ctx.IMAP.Logout(10 * time.Second)
+	}
+	return nil
+}
+
+// saveMatchingAttachment downloads the message at uid, and if it has an
+// attachment whose filename matches re, saves it to pathTemplate with
+// placeholders expanded.
+func saveMatchingAttachment(ctx *imaputil.ImapCtx, uid uint32, re *regexp.Regexp, pathTemplate string) error {
+	msgBytes, err := ctx.MessageByUID(uid)
+	if err != nil {
+		return err
+	}
+	if msgBytes == nil {
+		return nil
+	}
+
+	atts, err := mimeutil.Attachments(msgBytes)
+	if err != nil {
+		return err
+	}
+
+	for _, att := range atts {
+		if !re.MatchString(att.Filename) {
+			continue
+		}
+		outPath := expandPathTemplate(pathTemplate, uid, msgBytes)
+		err = os.MkdirAll(filepath.Dir(outPath), 0755)
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(outPath, att.Content, 0644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expandPathTemplate substitutes {uid}, {date}, {from}, and {subject} in
+// tmpl using the envelope of msgBytes.
+func expandPathTemplate(tmpl string, uid uint32, msgBytes []byte) string {
+	date, from, subject := "", "", ""
+	if m, err := mail.ReadMessage(bytes.NewReader(msgBytes)); err == nil {
+		date = m.Header.Get("Date")
+		from = m.Header.Get("From")
+		subject = m.Header.Get("Subject")
+	}
+
+	r := strings.NewReplacer(
+		"{uid}", fmt.Sprintf("%d", uid),
+		"{date}", sanitizePathSegment(date),
+		"{from}", sanitizePathSegment(from),
+		"{subject}", sanitizePathSegment(subject),
+	)
+	return r.Replace(tmpl)
+}
+
+// sanitizePathSegment strips characters that don't belong in a path segment.
+func sanitizePathSegment(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|':
+			return '_'
+		}
+		return r
+	}, s)
+	return strings.TrimSpace(s)
+}
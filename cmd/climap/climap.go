@@ -1,16 +1,19 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
 	"time"
 
 	humanize "github.com/dustin/go-humanize"
 	"github.com/yargevad/mailtools/imaputil"
+	"github.com/yargevad/mailtools/mailstore"
 	"github.com/yargevad/mailtools/mimeutil"
 )
 
@@ -18,19 +21,40 @@ var mbox = flag.String("mbox", "INBOX", "mailbox name")
 var newer = flag.String("newer", "", "message received date must be more recent")
 var subject = flag.String("subject", "", "message must contain substring in subject")
 var download = flag.Bool("download", false, "should matching messages be downloaded")
+var config = flag.String("config", "", "path to a batch config file (replaces flag-based invocation)")
+var watch = flag.Bool("watch", false, "after the initial search, stay connected and process new messages as they arrive")
+var store = flag.String("store", "", "path to a SQLite archive; when set, incrementally syncs new messages instead of running a one-off search")
+var moveTo = flag.String("move-to", "", "mailbox to move matching messages to, after download succeeds")
+var markSeen = flag.Bool("mark-seen", false, "mark matching messages \\Seen, after download succeeds")
+var deleteFlag = flag.Bool("delete", false, "mark matching messages \\Deleted, after download succeeds")
+var expunge = flag.Bool("expunge", false, "expunge the mailbox after applying --delete (ignored with --move-to, which always expunges)")
+var dryRun = flag.Bool("dry-run", false, "print planned STORE/MOVE/EXPUNGE commands instead of executing them")
+var groupByThread = flag.Bool("group-by-thread", false, "save downloaded messages under msgDir/<thread-root-uid>/<uid>.eml instead of a flat directory")
 
 func main() {
 	flag.Parse()
 
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
+	if *config != "" {
+		cfg, err := imaputil.LoadConfig(*config)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = RunConfig(cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	ctx, err := imaputil.EnvConnect("CLIMAP_")
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	baseDir := os.Getenv("CLIMAP_BASE")
-	if baseDir == "" && *download == true {
+	if baseDir == "" && (*download == true || *store != "") {
 		log.Fatal("No base directory set for saving messages! (CLIMAP_BASE)\n")
 	}
 
@@ -38,7 +62,7 @@ func main() {
 
 	log.Printf("Login successful for %s at %s\n", ctx.User, ctx.Host)
 
-	if *subject == "" && *newer == "" {
+	if *store == "" && *subject == "" && *newer == "" {
 		os.Exit(0)
 	}
 
@@ -49,7 +73,7 @@ func main() {
 
 	// Make sure there's a local mailbox directory
 	msgDir := fmt.Sprintf("%s/%s/%s", baseDir, ctx.User, ctx.IMAP.Mailbox.Name)
-	if *download == true {
+	if *download == true || *store != "" {
 		err = os.Mkdir(msgDir, 0755)
 		if err != nil {
 			if !os.IsExist(err) {
@@ -58,6 +82,20 @@ func main() {
 		}
 	}
 
+	if *store != "" {
+		mstore, err := mailstore.Open(*store)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer mstore.Close()
+
+		err = SyncMailbox(ctx, mstore, msgDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	var criteria []string
 	if *newer != "" {
 		dur, err := time.ParseDuration(*newer)
@@ -85,48 +123,121 @@ func main() {
 		log.Fatal(err)
 	}
 	log.Printf("search returned %d elements:\n", len(uids))
+
+	threadDir := map[uint32]string{}
+	if *groupByThread && *download == true {
+		threads, err := ctx.Threads(uids)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, thread := range threads {
+			if len(thread) == 0 {
+				continue
+			}
+			root := thread[0]
+			for _, uid := range thread {
+				threadDir[uid] = fmt.Sprintf("%s/%d", msgDir, root)
+			}
+		}
+	}
+
 	for idx, uid := range uids {
 		log.Printf("- uid=%d (%d/%d)\n", uid, idx, len(uids))
-		var msgBytes []byte
 		if *download == true {
-			msgFile := fmt.Sprintf("%s/%d.eml", msgDir, uid)
-			file, err := os.Open(msgFile)
-			if err == nil {
-				defer file.Close()
-				msgBytes, err = ioutil.ReadFile(msgFile)
-				if err != nil {
-					log.Fatal(err)
-				}
-				log.Printf("  file cached for uid %d: %s\n", uid, msgFile)
-			} else if os.IsNotExist(err) {
-				file, err := os.Create(msgFile)
-				if err != nil {
-					log.Fatal(err)
-				}
-				defer file.Close()
-
-				msgBytes, err := ctx.MessageByUID(uid)
-				n, err := file.Write(msgBytes)
-				if err == nil && n < len(msgBytes) {
-					err = io.ErrShortWrite
-				}
+			dir := msgDir
+			if d, ok := threadDir[uid]; ok {
+				dir = d
+				err = os.MkdirAll(dir, 0755)
 				if err != nil {
 					log.Fatal(err)
 				}
-				log.Printf("  saved %d bytes for uid %d\n", len(msgBytes), uid)
-
-			} else {
+			}
+			err = downloadAndExtract(ctx, dir, uid)
+			if err != nil {
 				log.Fatal(err)
 			}
+		}
+	}
+
+	err = applyTriage(ctx, uids)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-			if msgBytes != nil {
-				att, err := mimeutil.DecodeAttachment(msgBytes)
+	if *watch == true {
+		watchCtx, cancel := context.WithCancel(context.Background())
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt)
+		go func() {
+			<-sig
+			cancel()
+		}()
+
+		log.Printf("watching %s for new messages (ctrl-c to stop)\n", ctx.IMAP.Mailbox.Name)
+		err = ctx.Idle(watchCtx, func(ev imaputil.Event) {
+			arrived, ok := ev.(imaputil.MessageArrived)
+			if !ok {
+				return
+			}
+			log.Printf("- new message, uid=%d\n", arrived.UID)
+			if *download == true {
+				err := downloadAndExtract(ctx, msgDir, arrived.UID)
 				if err != nil {
-					log.Fatal(err)
+					log.Printf("  error handling uid=%d: %s\n", arrived.UID, err)
 				}
-				log.Printf("read %s from %s\n", humanize.Bytes(att.Length), att.Filename)
 			}
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// downloadAndExtract saves the message at uid into msgDir (or reads it from
+// cache if already saved), then decodes and logs its first attachment.
+func downloadAndExtract(ctx *imaputil.ImapCtx, msgDir string, uid uint32) error {
+	var msgBytes []byte
+	msgFile := fmt.Sprintf("%s/%d.eml", msgDir, uid)
+	file, err := os.Open(msgFile)
+	if err == nil {
+		defer file.Close()
+		msgBytes, err = ioutil.ReadFile(msgFile)
+		if err != nil {
+			return err
+		}
+		log.Printf("  file cached for uid %d: %s\n", uid, msgFile)
+	} else if os.IsNotExist(err) {
+		file, err := os.Create(msgFile)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		msgBytes, err = ctx.MessageByUID(uid)
+		if err != nil {
+			return err
+		}
+		n, err := file.Write(msgBytes)
+		if err == nil && n < len(msgBytes) {
+			err = io.ErrShortWrite
+		}
+		if err != nil {
+			return err
+		}
+		log.Printf("  saved %d bytes for uid %d\n", len(msgBytes), uid)
+	} else {
+		return err
+	}
 
+	if msgBytes != nil {
+		atts, err := mimeutil.Attachments(msgBytes)
+		if err != nil {
+			return err
+		}
+		if len(atts) > 0 {
+			log.Printf("read %s from %s\n", humanize.Bytes(atts[0].Length), atts[0].Filename)
 		}
 	}
+
+	return nil
 }
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/yargevad/mailtools/imaputil"
+	"github.com/yargevad/mailtools/mailstore"
+	"github.com/yargevad/mailtools/mimeutil"
+)
+
+// SyncMailbox incrementally archives the currently selected mailbox into
+// store: it reads the last UIDVALIDITY/UID seen, invalidates the cache if
+// UIDVALIDITY changed, and fetches only messages newer than the highest UID
+// already archived. Full bodies (and attachments, saved under msgDir) are
+// only downloaded for messages whose Message-ID isn't already archived.
+func SyncMailbox(ctx *imaputil.ImapCtx, store *mailstore.Store, msgDir string) error {
+	mailbox := ctx.IMAP.Mailbox.Name
+
+	err := store.SetUIDValidity(mailbox, ctx.UIDValidity())
+	if err != nil {
+		return err
+	}
+
+	_, lastUID, err := store.SyncState(mailbox)
+	if err != nil {
+		return err
+	}
+
+	msgs, err := ctx.FetchSince(lastUID + 1)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[%s] %d candidate messages since uid=%d\n", mailbox, len(msgs), lastUID)
+	for _, msg := range msgs {
+		err = syncOne(ctx, store, msgDir, mailbox, msg)
+		if err != nil {
+			return err
+		}
+		err = store.SetLastUID(mailbox, msg.UID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func syncOne(ctx *imaputil.ImapCtx, store *mailstore.Store, msgDir, mailbox string, msg *imaputil.FetchedMessage) error {
+	messageID := ""
+	subject := ""
+	if msg.Envelope != nil {
+		messageID = msg.Envelope.MessageID
+		subject = msg.Envelope.Subject
+	}
+
+	// A blank Message-ID (malformed/spam senders) can't be used for dedup:
+	// HasMessageID("") would match every prior Message-ID-less message in
+	// the mailbox and cause this one to be silently skipped forever.
+	if messageID != "" {
+		have, err := store.HasMessageID(mailbox, messageID)
+		if err != nil {
+			return err
+		}
+		if have {
+			log.Printf("  uid=%d already archived (message-id=%s)\n", msg.UID, messageID)
+			return nil
+		}
+	}
+
+	rowID, err := store.SaveMessage(&mailstore.Message{
+		Mailbox:      mailbox,
+		UID:          msg.UID,
+		MessageID:    messageID,
+		InternalDate: msg.InternalDate,
+		Subject:      subject,
+		Flags:        strings.Join(msg.Flags, " "),
+	})
+	if err != nil {
+		return err
+	}
+
+	if msg.Envelope != nil {
+		err = saveAddresses(store, rowID, msg.Envelope)
+		if err != nil {
+			return err
+		}
+	}
+
+	msgBytes, err := ctx.MessageByUID(msg.UID)
+	if err != nil {
+		return err
+	}
+
+	atts, err := mimeutil.Attachments(msgBytes)
+	if err != nil {
+		return err
+	}
+	for _, att := range atts {
+		diskPath := filepath.Join(msgDir, fmt.Sprintf("%d-%s", msg.UID, att.Filename))
+		err = ioutil.WriteFile(diskPath, att.Content, 0644)
+		if err != nil {
+			return err
+		}
+		err = store.SaveAttachment(rowID, att.Filename, "", att.Content, diskPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Printf("  archived uid=%d (%d attachments)\n", msg.UID, len(atts))
+	return nil
+}
+
+// saveAddresses records every From/To/Cc/Bcc address on env against messageRowID.
+func saveAddresses(store *mailstore.Store, messageRowID int64, env *imaputil.Envelope) error {
+	kinds := []struct {
+		kind  string
+		addrs []imaputil.Address
+	}{
+		{"from", env.From},
+		{"to", env.To},
+		{"cc", env.Cc},
+		{"bcc", env.Bcc},
+	}
+	for _, k := range kinds {
+		for _, a := range k.addrs {
+			err := store.SaveAddress(messageRowID, mailstore.Address{
+				Kind:    k.kind,
+				Name:    a.Name,
+				Mailbox: a.Mailbox,
+				Host:    a.Host,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
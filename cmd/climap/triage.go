@@ -0,0 +1,59 @@
+package main
+
+import (
+	"log"
+
+	"github.com/yargevad/mailtools/imaputil"
+)
+
+// applyTriage runs the --mark-seen/--delete/--move-to/--expunge flags
+// against uids, after download succeeds. With --dry-run, it only logs what
+// it would have done.
+func applyTriage(ctx *imaputil.ImapCtx, uids []uint32) error {
+	if len(uids) == 0 {
+		return nil
+	}
+
+	// ctx.Mailbox selects read-only; re-select read-write so STORE and
+	// EXPUNGE are allowed.
+	if !*dryRun && (*markSeen || *moveTo != "" || *deleteFlag || *expunge) {
+		if _, err := imaputil.CheckOK(ctx.IMAP.Select(ctx.IMAP.Mailbox.Name, false)); err != nil {
+			return err
+		}
+	}
+
+	if *markSeen {
+		if *dryRun {
+			log.Printf("[dry-run] STORE %v +FLAGS (\\Seen)\n", uids)
+		} else if err := ctx.MarkSeen(uids); err != nil {
+			return err
+		}
+	}
+
+	if *moveTo != "" {
+		if *dryRun {
+			log.Printf("[dry-run] MOVE %v -> %s\n", uids, *moveTo)
+		} else if err := ctx.Move(uids, *moveTo); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	if *deleteFlag {
+		if *dryRun {
+			log.Printf("[dry-run] STORE %v +FLAGS (\\Deleted)\n", uids)
+		} else if err := ctx.MarkDeleted(uids); err != nil {
+			return err
+		}
+	}
+
+	if *expunge {
+		if *dryRun {
+			log.Printf("[dry-run] EXPUNGE\n")
+		} else if err := ctx.Expunge(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
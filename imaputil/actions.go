@@ -0,0 +1,95 @@
+package imaputil
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mxk/go-imap/imap"
+)
+
+// FlagMode selects how SetFlags applies flags to a message's flag list.
+type FlagMode int
+
+const (
+	// FlagsAdd adds the given flags, leaving existing ones alone (STORE +FLAGS).
+	FlagsAdd FlagMode = iota
+	// FlagsRemove removes the given flags (STORE -FLAGS).
+	FlagsRemove
+	// FlagsSet replaces a message's flags outright (STORE FLAGS).
+	FlagsSet
+)
+
+func uidSeqSet(uids []uint32) (*imap.SeqSet, error) {
+	strs := make([]string, len(uids))
+	for i, uid := range uids {
+		strs[i] = fmt.Sprintf("%d", uid)
+	}
+	return imap.NewSeqSet(strings.Join(strs, ","))
+}
+
+// SetFlags applies flags to uids using the given mode.
+func (ctx *ImapCtx) SetFlags(uids []uint32, flags []string, mode FlagMode) error {
+	set, err := uidSeqSet(uids)
+	if err != nil {
+		return err
+	}
+
+	var item string
+	switch mode {
+	case FlagsAdd:
+		item = "+FLAGS"
+	case FlagsRemove:
+		item = "-FLAGS"
+	case FlagsSet:
+		item = "FLAGS"
+	default:
+		return fmt.Errorf("unknown FlagMode %d", mode)
+	}
+
+	flagList := make([]imap.Field, len(flags))
+	for i, f := range flags {
+		flagList[i] = f
+	}
+
+	_, err = CheckOK(ctx.IMAP.UIDStore(set, item, flagList))
+	return err
+}
+
+// MarkSeen adds \Seen to uids.
+func (ctx *ImapCtx) MarkSeen(uids []uint32) error {
+	return ctx.SetFlags(uids, []string{`\Seen`}, FlagsAdd)
+}
+
+// MarkDeleted adds \Deleted to uids.
+func (ctx *ImapCtx) MarkDeleted(uids []uint32) error {
+	return ctx.SetFlags(uids, []string{`\Deleted`}, FlagsAdd)
+}
+
+// Expunge permanently removes messages marked \Deleted from the selected mailbox.
+func (ctx *ImapCtx) Expunge() error {
+	_, err := CheckOK(ctx.IMAP.Expunge(nil))
+	return err
+}
+
+// Move relocates uids into dest via COPY + STORE \Deleted + EXPUNGE.
+//
+// It never issues the RFC 6851 MOVE command, even when the server's
+// CAPABILITY response advertises it: this go-imap fork's command table has
+// no registered "MOVE"/"UID MOVE" command, so ctx.IMAP.Send would always
+// fail with NotAvailableError regardless of what the server supports.
+func (ctx *ImapCtx) Move(uids []uint32, dest string) error {
+	set, err := uidSeqSet(uids)
+	if err != nil {
+		return err
+	}
+
+	_, err = CheckOK(ctx.IMAP.UIDCopy(set, dest))
+	if err != nil {
+		return err
+	}
+	err = ctx.MarkDeleted(uids)
+	if err != nil {
+		return err
+	}
+	return ctx.Expunge()
+}
@@ -0,0 +1,112 @@
+package imaputil
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/mxk/go-imap/imap"
+)
+
+// Authenticator logs an already-connected *imap.Client into the server.
+type Authenticator interface {
+	Authenticate(c *imap.Client) (*imap.Command, error)
+}
+
+// PlainAuth implements the plaintext IMAP LOGIN command.
+type PlainAuth struct {
+	User string
+	Pass string
+}
+
+// Authenticate implements Authenticator.
+func (a PlainAuth) Authenticate(c *imap.Client) (*imap.Command, error) {
+	defer c.SetLogMask(Sensitive(c, "LOGIN"))
+	return c.Login(a.User, a.Pass)
+}
+
+// CRAMMD5Auth stands in for the CRAM-MD5 SASL mechanism (RFC 2195); its
+// Authenticate always returns an error.
+//
+// CRAM-MD5 requires the server to speak first (it issues a challenge that
+// the client must hash and respond to), which means authenticating with it
+// needs a way to read a mid-command continuation and send a computed
+// response back. This go-imap fork doesn't expose that: the only
+// continuation handling it does is internal, for writing client-first
+// literals. Until that's available (by extending this dependency or
+// dropping to raw transport), CRAM-MD5 can't be implemented here — use
+// PlainAuth or XOAUTH2Auth instead.
+type CRAMMD5Auth struct {
+	User string
+	Pass string
+}
+
+// Authenticate implements Authenticator. It always fails; see the type doc.
+func (a CRAMMD5Auth) Authenticate(c *imap.Client) (*imap.Command, error) {
+	return nil, fmt.Errorf("CRAM-MD5 auth is not supported by this IMAP client library (no mid-command continuation API)")
+}
+
+// XOAUTH2Auth implements the XOAUTH2 SASL mechanism used by Gmail and Office 365.
+//
+// Unlike CRAM-MD5, XOAUTH2 supports SASL-IR (RFC 4959): the client speaks
+// first, so the whole payload can ride along on the initial AUTHENTICATE
+// command instead of needing a continuation round-trip.
+type XOAUTH2Auth struct {
+	User  string
+	Token string
+}
+
+// Authenticate implements Authenticator.
+func (a XOAUTH2Auth) Authenticate(c *imap.Client) (*imap.Command, error) {
+	defer c.SetLogMask(Sensitive(c, "AUTHENTICATE XOAUTH2"))
+
+	payload := fmt.Sprintf("user=%s\x01auth=Bearer %s\x01\x01", a.User, a.Token)
+	encoded := base64.StdEncoding.EncodeToString([]byte(payload))
+
+	return CheckOK(c.Send("AUTHENTICATE", "XOAUTH2", encoded))
+}
+
+// authFromEnv builds an Authenticator from the "<prefix>AUTH" environment
+// variable (plain, login, xoauth2; defaults to plain), reading whatever
+// additional <prefix>OAUTH_* variables that mechanism needs. cram-md5 is
+// recognised but always returns an error.
+func authFromEnv(prefix, user, pass string) (Authenticator, error) {
+	switch strings.ToLower(os.Getenv(fmt.Sprintf("%sAUTH", prefix))) {
+	case "", "plain", "login":
+		return PlainAuth{User: user, Pass: pass}, nil
+	case "cram-md5":
+		return nil, fmt.Errorf("%sAUTH=cram-md5 is not supported by this IMAP client library (no mid-command continuation API); use plain or xoauth2", prefix)
+	case "xoauth2":
+		token := os.Getenv(fmt.Sprintf("%sOAUTH_TOKEN", prefix))
+		if token == "" {
+			tokenCmd := os.Getenv(fmt.Sprintf("%sOAUTH_TOKEN_CMD", prefix))
+			if tokenCmd == "" {
+				return nil, fmt.Errorf("%sAUTH=xoauth2 set, but neither %sOAUTH_TOKEN nor %sOAUTH_TOKEN_CMD is set", prefix, prefix, prefix)
+			}
+			var err error
+			token, err = tokenFromCmd(tokenCmd)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return XOAUTH2Auth{User: user, Token: token}, nil
+	default:
+		return nil, fmt.Errorf("unknown %sAUTH value", prefix)
+	}
+}
+
+// tokenFromCmd runs cmdline and returns its trimmed stdout, for
+// CLIMAP_OAUTH_TOKEN_CMD-style "exec a helper that prints a fresh bearer token".
+func tokenFromCmd(cmdline string) (string, error) {
+	fields := strings.Fields(cmdline)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("empty token command")
+	}
+	out, err := exec.Command(fields[0], fields[1:]...).Output()
+	if err != nil {
+		return "", fmt.Errorf("error running token command [%s]: %s", cmdline, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,107 @@
+package imaputil
+
+import (
+	"fmt"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Config is the top-level structure loaded from a climap batch config file.
+// It describes any number of accounts to log into, and any number of rules
+// to apply against those accounts.
+type Config struct {
+	Account []AccountConfig
+	Rule    []RuleConfig
+}
+
+// AccountConfig holds the connection details for a single IMAP account.
+type AccountConfig struct {
+	Name          string
+	Host          string
+	User          string
+	Pass          string
+	TLSServerName string `toml:"tls_servername"`
+}
+
+// RuleConfig describes a single search/save operation to run against an
+// account. Since/Before are sent to the server as-is, without being parsed
+// or validated, so they must already use the "2-Jan-2006" format IMAP uses
+// for SINCE/BEFORE search terms.
+type RuleConfig struct {
+	Account string
+	Mailbox string
+	Since   string
+	Before  string
+	From    string
+	To      string
+	Subject string
+	Regexp  string
+	Path    string
+}
+
+// LoadConfig reads and parses a batch config file in TOML format.
+func LoadConfig(path string) (*Config, error) {
+	cfg := &Config{}
+	_, err := toml.DecodeFile(path, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config [%s]: %s", path, err)
+	}
+	return cfg, nil
+}
+
+// AccountByName returns the account config with the given name, if any.
+func (c *Config) AccountByName(name string) (*AccountConfig, error) {
+	for i := range c.Account {
+		if c.Account[i].Name == name {
+			return &c.Account[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no [account] named %q in config", name)
+}
+
+// SearchTerms builds IMAP search criteria from a rule's Since/Before/From/To/Subject fields.
+func (ctx *ImapCtx) SearchTerms(r *RuleConfig) ([]string, error) {
+	var terms []string
+
+	if r.Since != "" {
+		quoted, ok := ctx.IMAP.Quote(r.Since).(string)
+		if !ok {
+			return nil, fmt.Errorf("error quoting SINCE date [%s]", r.Since)
+		}
+		terms = append(terms, "SINCE", quoted)
+	}
+
+	if r.Before != "" {
+		quoted, ok := ctx.IMAP.Quote(r.Before).(string)
+		if !ok {
+			return nil, fmt.Errorf("error quoting BEFORE date [%s]", r.Before)
+		}
+		terms = append(terms, "BEFORE", quoted)
+	}
+
+	if r.From != "" {
+		quoted, ok := ctx.IMAP.Quote(r.From).(string)
+		if !ok {
+			return nil, fmt.Errorf("error quoting FROM [%s]", r.From)
+		}
+		terms = append(terms, "FROM", quoted)
+	}
+
+	if r.To != "" {
+		quoted, ok := ctx.IMAP.Quote(r.To).(string)
+		if !ok {
+			return nil, fmt.Errorf("error quoting TO [%s]", r.To)
+		}
+		terms = append(terms, "TO", quoted)
+	}
+
+	if r.Subject != "" {
+		quoted, ok := ctx.IMAP.Quote(r.Subject).(string)
+		if !ok {
+			return nil, fmt.Errorf("error quoting SUBJECT [%s]", r.Subject)
+		}
+		terms = append(terms, "SUBJECT", quoted)
+	}
+
+	return terms, nil
+}
@@ -0,0 +1,272 @@
+package imaputil
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/mxk/go-imap/imap"
+)
+
+// Event is the common interface implemented by everything Idle delivers to
+// its handler.
+type Event interface {
+	isEvent()
+}
+
+// MessageArrived is delivered when a new message appears in the watched mailbox.
+type MessageArrived struct {
+	UID     uint32
+	Mailbox string
+}
+
+// MessageExpunged is delivered when a message is removed from the watched mailbox.
+type MessageExpunged struct {
+	UID uint32
+}
+
+// FlagsChanged is delivered when a message's flags are updated.
+type FlagsChanged struct {
+	UID   uint32
+	Flags []string
+}
+
+func (MessageArrived) isEvent()  {}
+func (MessageExpunged) isEvent() {}
+func (FlagsChanged) isEvent()    {}
+
+// idlePollInterval is the NOOP cadence used for servers that don't advertise IDLE.
+const idlePollInterval = 30 * time.Second
+
+// idleRecvTimeout bounds each read while a real IDLE command is outstanding,
+// so idleWait can periodically check pctx and the renewal deadline below
+// instead of blocking on the wire indefinitely.
+const idleRecvTimeout = 10 * time.Second
+
+// idleRenewInterval reissues IDLE before it's been outstanding this long.
+// RFC 2177 warns clients to re-issue IDLE periodically since servers may
+// apply their own inactivity timeout (commonly around 30 minutes);
+// renewing well under that keeps a long-running watch from being dropped.
+const idleRenewInterval = 25 * time.Minute
+
+// Idle watches the currently selected mailbox for new/removed/updated
+// messages, delivering translated events to handler until pctx is done.
+//
+// When the server advertises IDLE (RFC 2177), it's used directly: this
+// go-imap fork's command table does register IDLE, and ctx.IMAP.Idle()/
+// IdleTerm() already perform the continuation handshake correctly. Servers
+// that don't advertise IDLE fall back to NOOP polling.
+func (ctx *ImapCtx) Idle(pctx context.Context, handler func(Event)) error {
+	if !ctx.IMAP.Caps["IDLE"] {
+		return ctx.idlePoll(pctx, handler)
+	}
+	return ctx.idleWait(pctx, handler)
+}
+
+// idleWait keeps a real IMAP IDLE command outstanding, re-issuing it every
+// idleRenewInterval, until pctx is done.
+func (ctx *ImapCtx) idleWait(pctx context.Context, handler func(Event)) error {
+	seqUID := map[uint32]uint32{}
+	count, err := ctx.seedSeqUIDCache(seqUID)
+	if err != nil {
+		return err
+	}
+
+	for pctx.Err() == nil {
+		if _, err := ctx.IMAP.Idle(); err != nil {
+			return err
+		}
+		count, err = ctx.waitOneIdle(pctx, seqUID, count, handler)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitOneIdle reads unsolicited responses for a single outstanding IDLE
+// command until pctx is done or idleRenewInterval elapses, then sends DONE
+// to end it.
+func (ctx *ImapCtx) waitOneIdle(pctx context.Context, seqUID map[uint32]uint32, count uint32, handler func(Event)) (uint32, error) {
+	deadline := time.Now().Add(idleRenewInterval)
+
+	for pctx.Err() == nil && time.Now().Before(deadline) {
+		_, err := ctx.IMAP.Recv(idleRecvTimeout)
+		if err != nil {
+			if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+				continue
+			}
+			ctx.IMAP.IdleTerm()
+			return count, err
+		}
+
+		if len(ctx.IMAP.Data) > 0 {
+			data := ctx.IMAP.Data
+			ctx.IMAP.Data = nil
+			count, err = ctx.dispatchUnilateral(data, seqUID, count, handler)
+			if err != nil {
+				ctx.IMAP.IdleTerm()
+				return count, err
+			}
+		}
+	}
+
+	_, err := ctx.IMAP.IdleTerm()
+	return count, err
+}
+
+// idlePoll polls with NOOP every idlePollInterval, translating the
+// EXISTS/EXPUNGE/FETCH untagged responses each NOOP picks up into events.
+//
+// EXISTS reports the mailbox's new total message count, and EXPUNGE reports
+// the sequence number of the removed message — neither is a UID on its own,
+// so a local sequence->UID cache (seeded once up front, and kept in sync as
+// messages arrive/leave) is used to resolve both to real UIDs.
+func (ctx *ImapCtx) idlePoll(pctx context.Context, handler func(Event)) error {
+	seqUID := map[uint32]uint32{}
+	count, err := ctx.seedSeqUIDCache(seqUID)
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(idlePollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-pctx.Done():
+			return nil
+		case <-ticker.C:
+			cmd, err := CheckOK(ctx.IMAP.Noop())
+			if err != nil {
+				return err
+			}
+			data := append(cmd.Data, ctx.IMAP.Data...)
+			ctx.IMAP.Data = nil
+			count, err = ctx.dispatchUnilateral(data, seqUID, count, handler)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// seedSeqUIDCache fetches the UID of every message currently in the
+// selected mailbox, keyed by sequence number, so later EXPUNGE responses
+// (which only give a sequence number) can be resolved to a UID.
+func (ctx *ImapCtx) seedSeqUIDCache(seqUID map[uint32]uint32) (uint32, error) {
+	count := ctx.IMAP.Mailbox.Messages
+	if count == 0 {
+		return 0, nil
+	}
+
+	set, err := imap.NewSeqSet(fmt.Sprintf("1:%d", count))
+	if err != nil {
+		return 0, err
+	}
+	cmd, err := CheckOK(ctx.IMAP.Fetch(set, "UID"))
+	if err != nil {
+		return 0, err
+	}
+
+	for _, rsp := range cmd.Data {
+		if rsp.Label != "FETCH" {
+			continue
+		}
+		fmap := imap.AsFieldMap(rsp.Fields[len(rsp.Fields)-1])
+		if uid, ok := fmap["UID"].(uint32); ok {
+			seqUID[rsp.Value()] = uid
+		}
+	}
+	return count, nil
+}
+
+// dispatchUnilateral translates EXISTS/EXPUNGE/FETCH untagged responses
+// into typed Events, and returns the mailbox's message count as of after
+// processing them.
+func (ctx *ImapCtx) dispatchUnilateral(rsps []*imap.Response, seqUID map[uint32]uint32, count uint32, handler func(Event)) (uint32, error) {
+	mailbox := ""
+	if ctx.IMAP.Mailbox != nil {
+		mailbox = ctx.IMAP.Mailbox.Name
+	}
+
+	newCount := count
+	// known is how many of the count messages seen before this batch are
+	// still present; new messages occupy sequence numbers above it.
+	known := count
+	for _, rsp := range rsps {
+		switch rsp.Label {
+		case "EXISTS":
+			newCount = rsp.Value()
+
+		case "EXPUNGE":
+			seq := rsp.Value()
+			if uid, ok := seqUID[seq]; ok {
+				handler(MessageExpunged{UID: uid})
+				delete(seqUID, seq)
+			}
+			renumberAfterExpunge(seqUID, seq, newCount)
+			if newCount > 0 {
+				newCount--
+			}
+			if seq <= known && known > 0 {
+				known--
+			}
+
+		case "FETCH":
+			fmap := imap.AsFieldMap(rsp.Fields[len(rsp.Fields)-1])
+			uid, ok := fmap["UID"].(uint32)
+			if !ok {
+				continue
+			}
+			seqUID[rsp.Value()] = uid
+
+			var flags []string
+			if flagField, ok := fmap["FLAGS"]; ok {
+				for _, f := range imap.AsList(flagField) {
+					flags = append(flags, imap.AsString(f))
+				}
+			}
+			handler(FlagsChanged{UID: uid, Flags: flags})
+		}
+	}
+
+	if newCount > known {
+		set, err := imap.NewSeqSet(fmt.Sprintf("%d:%d", known+1, newCount))
+		if err != nil {
+			return count, err
+		}
+		fcmd, err := CheckOK(ctx.IMAP.Fetch(set, "UID"))
+		if err != nil {
+			return count, err
+		}
+		for _, rsp := range fcmd.Data {
+			if rsp.Label != "FETCH" {
+				continue
+			}
+			fmap := imap.AsFieldMap(rsp.Fields[len(rsp.Fields)-1])
+			uid, ok := fmap["UID"].(uint32)
+			if !ok {
+				continue
+			}
+			seqUID[rsp.Value()] = uid
+			handler(MessageArrived{UID: uid, Mailbox: mailbox})
+		}
+	}
+
+	return newCount, nil
+}
+
+// renumberAfterExpunge shifts every cached sequence number above expunged
+// down by one, per RFC 3501's expunge semantics (all messages after the
+// removed one renumber immediately). total is the message count before
+// this expunge was applied.
+func renumberAfterExpunge(seqUID map[uint32]uint32, expunged, total uint32) {
+	for seq := expunged + 1; seq <= total; seq++ {
+		if uid, ok := seqUID[seq]; ok {
+			delete(seqUID, seq)
+			seqUID[seq-1] = uid
+		}
+	}
+}
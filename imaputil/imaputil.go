@@ -10,21 +10,56 @@ import (
 	"github.com/mxk/go-imap/imap"
 )
 
+// TLSMode selects how ImapCtx.Connect establishes (or doesn't establish) TLS.
+type TLSMode string
+
+const (
+	// TLSImplicit dials straight into TLS (the historical default, port 993).
+	TLSImplicit TLSMode = "implicit"
+	// TLSStartTLS dials in the clear and upgrades via STARTTLS (port 143).
+	TLSStartTLS TLSMode = "starttls"
+	// TLSNone never uses TLS at all.
+	TLSNone TLSMode = "none"
+)
+
 type ImapCtx struct {
 	Host string
 	User string
 	Pass string
 	TLS  tls.Config
+	// TLSMode selects implicit/STARTTLS/no TLS. Defaults to TLSImplicit.
+	TLSMode TLSMode
+	// Auth selects how Login authenticates. Defaults to plaintext LOGIN.
+	Auth Authenticator
 	IMAP *imap.Client
 }
 
 // Connect reaches out to the server. It doesn't login, yet.
 func (ctx *ImapCtx) Connect() error {
-	c, err := imap.DialTLS(ctx.Host, &ctx.TLS)
-	if err != nil {
-		return err
+	switch ctx.TLSMode {
+	case TLSStartTLS:
+		c, err := imap.Dial(ctx.Host)
+		if err != nil {
+			return err
+		}
+		_, err = CheckOK(c.StartTLS(&ctx.TLS))
+		if err != nil {
+			return err
+		}
+		ctx.IMAP = c
+	case TLSNone:
+		c, err := imap.Dial(ctx.Host)
+		if err != nil {
+			return err
+		}
+		ctx.IMAP = c
+	default:
+		c, err := imap.DialTLS(ctx.Host, &ctx.TLS)
+		if err != nil {
+			return err
+		}
+		ctx.IMAP = c
 	}
-	ctx.IMAP = c
 	return nil
 }
 
@@ -37,10 +72,14 @@ func (ctx *ImapCtx) Ping() error {
 	return nil
 }
 
-// Login authenticates with the server using the configured credentials.
+// Login authenticates with the server using ctx.Auth, falling back to
+// plaintext LOGIN with ctx.User/ctx.Pass if no Authenticator was set.
 func (ctx *ImapCtx) Login() (*imap.Command, error) {
-	defer ctx.IMAP.SetLogMask(Sensitive(ctx.IMAP, "LOGIN"))
-	return ctx.IMAP.Login(ctx.User, ctx.Pass)
+	auth := ctx.Auth
+	if auth == nil {
+		auth = PlainAuth{User: ctx.User, Pass: ctx.Pass}
+	}
+	return auth.Authenticate(ctx.IMAP)
 }
 
 // Init is a convenience method which calls Connect, Ping, then Login.
@@ -89,7 +128,22 @@ func EnvConnect(prefix string) (*ImapCtx, error) {
 		ctx.TLS.ServerName = serverName
 	}
 
-	err := ctx.Init()
+	switch TLSMode(os.Getenv(fmt.Sprintf("%sTLS", prefix))) {
+	case TLSStartTLS:
+		ctx.TLSMode = TLSStartTLS
+	case TLSNone:
+		ctx.TLSMode = TLSNone
+	default:
+		ctx.TLSMode = TLSImplicit
+	}
+
+	auth, err := authFromEnv(prefix, ctx.User, ctx.Pass)
+	if err != nil {
+		return nil, err
+	}
+	ctx.Auth = auth
+
+	err = ctx.Init()
 	if err != nil {
 		if strings.HasPrefix(err.Error(), "x509: certificate is valid for ") {
 			return nil, fmt.Errorf("%s; HINT: set %sTLS_SERVERNAME to work around certificate domain mismatches", err, prefix)
@@ -147,6 +201,34 @@ func (ctx *ImapCtx) Search(terms []string) (uids []uint32, err error) {
 	return uids, nil
 }
 
+// UIDValidity returns the UIDVALIDITY of the currently selected mailbox.
+func (ctx *ImapCtx) UIDValidity() uint32 {
+	return ctx.IMAP.Mailbox.UIDValidity
+}
+
+// SearchUIDRange returns the UIDs of messages in the selected mailbox with
+// UID >= from, for incremental sync against a local cache.
+func (ctx *ImapCtx) SearchUIDRange(from uint32) ([]uint32, error) {
+	set, err := imap.NewSeqSet(fmt.Sprintf("%d:*", from))
+	if err != nil {
+		return nil, err
+	}
+	cmd, err := CheckOK(ctx.IMAP.UIDSearch("UID", set))
+	if err != nil {
+		return nil, err
+	}
+	var uids []uint32
+	for _, rsp := range cmd.Data {
+		for _, uid := range rsp.SearchResults() {
+			// "<from>:*" still matches the highest UID when it is below from.
+			if uid >= from {
+				uids = append(uids, uid)
+			}
+		}
+	}
+	return uids, nil
+}
+
 func (ctx *ImapCtx) MessageByUID(uid uint32) ([]byte, error) {
 	return ctx.PartByUID(uid, "BODY[]")
 }
@@ -0,0 +1,215 @@
+// Package mailstore persists fetched IMAP messages into a local SQLite
+// database, so that repeat runs of climap can skip re-downloading messages
+// they've already archived.
+package mailstore
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS mailboxes (
+	name        TEXT PRIMARY KEY,
+	uidvalidity INTEGER NOT NULL,
+	last_uid    INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS messages (
+	id           INTEGER PRIMARY KEY AUTOINCREMENT,
+	mailbox      TEXT NOT NULL,
+	uid          INTEGER NOT NULL,
+	message_id   TEXT NOT NULL,
+	internaldate DATETIME,
+	subject      TEXT,
+	flags        TEXT,
+	UNIQUE(mailbox, uid)
+);
+
+CREATE TABLE IF NOT EXISTS addresses (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	message_id INTEGER NOT NULL REFERENCES messages(id),
+	kind       TEXT NOT NULL,
+	name       TEXT,
+	mailbox    TEXT,
+	host       TEXT
+);
+
+CREATE TABLE IF NOT EXISTS attachments (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	message_id INTEGER NOT NULL REFERENCES messages(id),
+	filename   TEXT,
+	mime_type  TEXT,
+	sha256     TEXT,
+	path       TEXT
+);
+`
+
+// Store is a local SQLite-backed archive of fetched IMAP messages.
+type Store struct {
+	DB *sql.DB
+}
+
+// Open creates (if needed) and opens the SQLite database at path, applying the schema.
+func Open(path string) (*Store, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error applying schema: %s", err)
+	}
+	return &Store{DB: db}, nil
+}
+
+// Close closes the underlying database.
+func (s *Store) Close() error {
+	return s.DB.Close()
+}
+
+// SyncState returns the last-seen UIDVALIDITY and highest archived UID for
+// mailbox, or zero values if it hasn't been synced before.
+func (s *Store) SyncState(mailbox string) (uidvalidity uint32, lastUID uint32, err error) {
+	row := s.DB.QueryRow(`SELECT uidvalidity, last_uid FROM mailboxes WHERE name = ?`, mailbox)
+	err = row.Scan(&uidvalidity, &lastUID)
+	if err == sql.ErrNoRows {
+		return 0, 0, nil
+	}
+	return uidvalidity, lastUID, err
+}
+
+// SetUIDValidity records mailbox's current UIDVALIDITY. If it differs from
+// what's stored, every UID in this mailbox may now refer to a different
+// message, so the archive for it is no longer trustworthy: last_uid is
+// reset to 0 and the mailbox's messages (and their addresses/attachments)
+// are deleted outright, rather than just left in place under stale UIDs.
+// Leaving them would make HasMessageID (keyed on Message-Id alone) report
+// every message as already archived on the resync that follows, even
+// though SetLastUID had started counting from zero again.
+func (s *Store) SetUIDValidity(mailbox string, uidvalidity uint32) error {
+	prevValidity, _, err := s.SyncState(mailbox)
+	if err != nil {
+		return err
+	}
+	if prevValidity == uidvalidity {
+		return nil
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT id FROM messages WHERE mailbox = ?`, mailbox)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var rowIDs []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		rowIDs = append(rowIDs, id)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range rowIDs {
+		if _, err := tx.Exec(`DELETE FROM attachments WHERE message_id = ?`, id); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM addresses WHERE message_id = ?`, id); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM messages WHERE mailbox = ?`, mailbox); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO mailboxes (name, uidvalidity, last_uid) VALUES (?, ?, 0)
+		ON CONFLICT(name) DO UPDATE SET uidvalidity = excluded.uidvalidity, last_uid = 0
+	`, mailbox, uidvalidity); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SetLastUID records the highest UID archived so far for mailbox.
+func (s *Store) SetLastUID(mailbox string, uid uint32) error {
+	_, err := s.DB.Exec(`UPDATE mailboxes SET last_uid = ? WHERE name = ? AND last_uid < ?`, uid, mailbox, uid)
+	return err
+}
+
+// HasMessageID reports whether a message with the given Message-ID has
+// already been archived for mailbox.
+func (s *Store) HasMessageID(mailbox, messageID string) (bool, error) {
+	var n int
+	row := s.DB.QueryRow(`SELECT COUNT(*) FROM messages WHERE mailbox = ? AND message_id = ?`, mailbox, messageID)
+	err := row.Scan(&n)
+	return n > 0, err
+}
+
+// Message is an archived message's envelope, ready for insertion.
+type Message struct {
+	Mailbox      string
+	UID          uint32
+	MessageID    string
+	InternalDate time.Time
+	Subject      string
+	Flags        string
+}
+
+// SaveMessage inserts a message row, returning its local row id.
+func (s *Store) SaveMessage(m *Message) (int64, error) {
+	res, err := s.DB.Exec(`
+		INSERT OR IGNORE INTO messages (mailbox, uid, message_id, internaldate, subject, flags)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, m.Mailbox, m.UID, m.MessageID, m.InternalDate, m.Subject, m.Flags)
+	if err != nil {
+		return 0, err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		// The (mailbox, uid) row already existed, so LastInsertId isn't its id.
+		var id int64
+		err = s.DB.QueryRow(`SELECT id FROM messages WHERE mailbox = ? AND uid = ?`, m.Mailbox, m.UID).Scan(&id)
+		return id, err
+	}
+	return res.LastInsertId()
+}
+
+// Address is a single envelope address (From/To/Cc/Bcc), ready for insertion.
+type Address struct {
+	Kind    string
+	Name    string
+	Mailbox string
+	Host    string
+}
+
+// SaveAddress records one envelope address against messageRowID.
+func (s *Store) SaveAddress(messageRowID int64, a Address) error {
+	_, err := s.DB.Exec(`
+		INSERT INTO addresses (message_id, kind, name, mailbox, host) VALUES (?, ?, ?, ?, ?)
+	`, messageRowID, a.Kind, a.Name, a.Mailbox, a.Host)
+	return err
+}
+
+// SaveAttachment records an attachment that's already been written to disk at diskPath.
+func (s *Store) SaveAttachment(messageRowID int64, filename, mimeType string, content []byte, diskPath string) error {
+	sum := sha256.Sum256(content)
+	_, err := s.DB.Exec(`
+		INSERT INTO attachments (message_id, filename, mime_type, sha256, path) VALUES (?, ?, ?, ?, ?)
+	`, messageRowID, filename, mimeType, hex.EncodeToString(sum[:]), diskPath)
+	return err
+}
@@ -6,23 +6,41 @@ import (
 	"encoding/base64"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"mime"
 	"mime/multipart"
+	"mime/quotedprintable"
 	"net/mail"
+	"net/textproto"
 	"strings"
-	"unicode"
-)
 
-const (
-	defaultBufSize = 4096
+	"golang.org/x/text/encoding/ianaindex"
 )
 
+var headerDecoder = new(mime.WordDecoder)
+
+// Part is a single leaf of a (possibly nested) multipart MIME message. Its
+// headers are already RFC 2047 decoded, and Reader returns its body with
+// Content-Transfer-Encoding and charset already undone.
+type Part struct {
+	Header      textproto.MIMEHeader
+	ContentType string
+	Params      map[string]string
+	Disposition string
+	DispParams  map[string]string
+	Filename    string
+
+	body io.Reader
+}
+
+// Reader returns the part's decoded body.
+func (p Part) Reader() io.Reader { return p.body }
+
+// Attachment is a fully-read attachment, as returned by Attachments.
 type Attachment struct {
 	Filename string
 	Length   uint64
 	Content  []byte
-	frags    [][]byte
-	encoding string
 }
 
 func GenBoundary() ([]byte, error) {
@@ -36,81 +54,177 @@ func GenBoundary() ([]byte, error) {
 	return enc[:], nil
 }
 
-// DecodeAttachment returns the content of the first attachment in a multipart MIME message.
-func DecodeAttachment(msg []byte) (*Attachment, error) {
-	msgBuf := bytes.NewReader(msg)
-	m, err := mail.ReadMessage(msgBuf)
+// Walk parses msg as a MIME message, flat or multipart with arbitrary
+// nesting (alternative, related, mixed, signed, ...), and calls visit once
+// per leaf part in document order.
+func Walk(msg []byte, visit func(Part) error) error {
+	m, err := mail.ReadMessage(bytes.NewReader(msg))
 	if err != nil {
-		return nil, err
+		return err
 	}
+	return walkPart(textproto.MIMEHeader(m.Header), m.Body, visit)
+}
 
-	ctype := m.Header.Get("Content-Type")
+func walkPart(header textproto.MIMEHeader, body io.Reader, visit func(Part) error) error {
+	ctype := header.Get("Content-Type")
+	if ctype == "" {
+		ctype = "text/plain; charset=us-ascii"
+	}
 	mtype, params, err := mime.ParseMediaType(ctype)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("error parsing Content-Type [%s]: %s", ctype, err)
 	}
 
-	if !strings.HasPrefix(mtype, "multipart/") {
-		return nil, fmt.Errorf("Unsupported top-level Content-Type [%s]", mtype)
+	if strings.HasPrefix(mtype, "multipart/") {
+		boundary, ok := params["boundary"]
+		if !ok {
+			return fmt.Errorf("multipart Content-Type [%s] has no boundary", ctype)
+		}
+		mpr := multipart.NewReader(body, boundary)
+		for {
+			child, err := mpr.NextPart()
+			if err != nil {
+				if err == io.EOF {
+					return nil
+				}
+				return err
+			}
+			err = walkPart(textproto.MIMEHeader(child.Header), child, visit)
+			if err != nil {
+				return err
+			}
+		}
 	}
 
-	if _, ok := params["boundary"]; !ok {
-		return nil, fmt.Errorf("No boundary in Content-Type!")
+	disp, dispParams, err := mime.ParseMediaType(header.Get("Content-Disposition"))
+	if err != nil {
+		disp, dispParams = "", nil
 	}
 
-	msgBuf.Seek(0, 0)
-	mpr := multipart.NewReader(msgBuf, params["boundary"])
+	decoded, err := decodeTransfer(body, header.Get("Content-Transfer-Encoding"))
+	if err != nil {
+		return err
+	}
+	decoded = decodeCharset(decoded, params["charset"])
 
-	for {
-		part, err := mpr.NextPart()
-		if err != nil {
-			if err == io.EOF {
-				break
+	decodedHeader := make(textproto.MIMEHeader, len(header))
+	for k, vs := range header {
+		for _, v := range vs {
+			if d, err := headerDecoder.DecodeHeader(v); err == nil {
+				v = d
 			}
-			return nil, err
+			decodedHeader[k] = append(decodedHeader[k], v)
 		}
+	}
 
-		// TODO: require that filenames match a pattern
-		if attFile := part.FileName(); attFile != "" {
-			var bufs [][]byte
-			attLen := uint64(0)
-			for {
-				buf := make([]byte, 4*1024)
-				n, err := part.Read(buf)
-				if err != nil {
-					if err == io.EOF {
-						break
-					}
-					return nil, err
-				}
-				attLen += uint64(n)
-				bufs = append(bufs, buf[:n])
-			}
-			att := &Attachment{Filename: attFile, Length: attLen, frags: bufs}
-			att.encoding = part.Header.Get("Content-Transfer-Encoding")
-			att.Content = bytes.Join(att.frags, []byte(""))
-			if att.encoding == "" {
-			} else if att.encoding == "base64" {
-				// remove whitespace
-				tmp := bytes.Map(func(r rune) rune {
-					if unicode.IsSpace(r) {
-						return -1
-					}
-					return r
-				}, att.Content)
-				n, err := base64.StdEncoding.Decode(att.Content, tmp)
-				if err != nil {
-					return nil, err
-				}
-				att.Content = att.Content[:n]
-				att.Length = uint64(n)
+	return visit(Part{
+		Header:      decodedHeader,
+		ContentType: mtype,
+		Params:      params,
+		Disposition: disp,
+		DispParams:  dispParams,
+		Filename:    partFilename(dispParams, params),
+		body:        decoded,
+	})
+}
 
-			} else {
-				return att, fmt.Errorf("Unsupported Content-Transfer-Encoding [%s]", att.encoding)
-			}
-			return att, nil
-		}
+// partFilename pulls a part's filename out of Content-Disposition's
+// "filename" param, falling back to Content-Type's "name" param, decoding
+// RFC 2047 encoded-words (e.g. `=?utf-8?B?...?=`) either way.
+func partFilename(dispParams, ctypeParams map[string]string) string {
+	name := dispParams["filename"]
+	if name == "" {
+		name = ctypeParams["name"]
+	}
+	if name == "" {
+		return ""
+	}
+	if decoded, err := headerDecoder.DecodeHeader(name); err == nil {
+		return decoded
+	}
+	return name
+}
+
+// decodeTransfer wraps body in a reader that undoes the given
+// Content-Transfer-Encoding. 7bit/8bit/binary (and no encoding at all) need
+// no translation.
+func decodeTransfer(body io.Reader, encoding string) (io.Reader, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", "7bit", "8bit", "binary":
+		return body, nil
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, body), nil
+	case "quoted-printable":
+		return quotedprintable.NewReader(body), nil
+	default:
+		return nil, fmt.Errorf("unsupported Content-Transfer-Encoding [%s]", encoding)
+	}
+}
+
+// decodeCharset wraps body in a reader that transcodes it to UTF-8, if
+// charset names an encoding other than US-ASCII/UTF-8 that we recognize.
+// Unrecognized charsets are passed through unmodified.
+func decodeCharset(body io.Reader, charset string) io.Reader {
+	charset = strings.TrimSpace(charset)
+	if charset == "" || strings.EqualFold(charset, "utf-8") || strings.EqualFold(charset, "us-ascii") {
+		return body
+	}
+	enc, err := ianaindex.MIME.Encoding(charset)
+	if err != nil || enc == nil {
+		return body
+	}
+	return enc.NewDecoder().Reader(body)
+}
 
+// Attachments walks msg and returns every part carrying a filename (via
+// Content-Disposition or a Content-Type "name" param), fully read into
+// memory.
+func Attachments(msg []byte) ([]*Attachment, error) {
+	var atts []*Attachment
+	err := Walk(msg, func(p Part) error {
+		if p.Filename == "" {
+			return nil
+		}
+		content, err := ioutil.ReadAll(p.Reader())
+		if err != nil {
+			return err
+		}
+		atts = append(atts, &Attachment{
+			Filename: p.Filename,
+			Length:   uint64(len(content)),
+			Content:  content,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	return nil, nil
+	return atts, nil
+}
+
+// Bodies walks msg and returns its decoded text/plain and text/html bodies
+// (the first of each found), so callers can index message content instead
+// of just saving attachments.
+func Bodies(msg []byte) (text string, html string, err error) {
+	err = Walk(msg, func(p Part) error {
+		if p.Filename != "" {
+			return nil
+		}
+		switch {
+		case p.ContentType == "text/plain" && text == "":
+			b, err := ioutil.ReadAll(p.Reader())
+			if err != nil {
+				return err
+			}
+			text = string(b)
+		case p.ContentType == "text/html" && html == "":
+			b, err := ioutil.ReadAll(p.Reader())
+			if err != nil {
+				return err
+			}
+			html = string(b)
+		}
+		return nil
+	})
+	return text, html, err
 }